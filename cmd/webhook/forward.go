@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// urlList is a repeatable -forward flag value.
+type urlList []string
+
+func (l *urlList) String() string { return strings.Join(*l, ",") }
+
+func (l *urlList) Set(s string) error {
+	if _, err := url.Parse(s); err != nil {
+		return fmt.Errorf("invalid -forward %q: %v", s, err)
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+type destination struct {
+	url    string
+	secret string
+}
+
+// forwarder re-emits verified payloads as HTTP POSTs to one or more
+// downstream URLs, re-signing each with its own secret.
+type forwarder struct {
+	destinations []destination
+	client       *http.Client
+}
+
+// newForwarder builds a forwarder for urls. secretsFile, if non-empty,
+// is a JSON object mapping a destination URL to the secret used to sign
+// requests sent to it; destinations absent from the file are forwarded
+// unsigned. caFile, if non-empty, is a PEM file of additional root CAs
+// trusted for HTTPS destinations.
+func newForwarder(urls []string, secretsFile, caFile string) (*forwarder, error) {
+	secrets := map[string]string{}
+	if secretsFile != "" {
+		b, err := ioutil.ReadFile(secretsFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &secrets); err != nil {
+			return nil, fmt.Errorf("invalid -forward-secrets config: %v", err)
+		}
+	}
+	dests := make([]destination, len(urls))
+	for i, u := range urls {
+		dests[i] = destination{url: u, secret: secrets[u]}
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	return &forwarder{destinations: dests, client: client}, nil
+}
+
+// Forward fans out body to every configured destination concurrently.
+// It returns without waiting for any destination. Failures are logged,
+// not returned: forwarding never affects the response sent to GitHub.
+func (f *forwarder) Forward(logger *slog.Logger, event, delivery string, body []byte) {
+	for _, d := range f.destinations {
+		go f.forwardOne(logger, d, event, delivery, body)
+	}
+}
+
+func (f *forwarder) forwardOne(logger *slog.Logger, d destination, event, delivery string, body []byte) {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("forward: building request", "url", d.url, "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", delivery)
+	if d.secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write(body)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	if u, err := url.Parse(d.url); err == nil {
+		req.Host = u.Host
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		logger.Error("forward: request failed", "url", d.url, "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.Error("forward: destination rejected payload", "url", d.url, "status", resp.Status)
+	}
+}
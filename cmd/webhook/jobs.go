@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rjeczalik/gh/webhook"
+)
+
+// JobStatus is the lifecycle state of a queued job.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job records the outcome of a single dispatched event.
+type Job struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Delivery  string    `json:"delivery,omitempty"`
+	Status    JobStatus `json:"status"`
+	ExitCode  int       `json:"exit_code"`
+	Log       string    `json:"log"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// runner is implemented by the template/notifier handlers the job queue
+// executes. It returns the handler's captured output as the job's log.
+type runner interface {
+	Run(logger *slog.Logger, event string, payload interface{}) (string, error)
+}
+
+type task struct {
+	job    *Job
+	run    func() (string, error)
+	logger *slog.Logger
+}
+
+// jobQueue dispatches tasks to a bounded pool of workers, keeping a
+// rolling buffer of the most recent jobs in memory and, optionally, a
+// JSON file per job on disk.
+type jobQueue struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	order    []string
+	capacity int
+	dir      string
+	tasks    chan task
+	nextID   uint64
+}
+
+// newJobQueue starts workers goroutines and returns a jobQueue that
+// keeps at most capacity jobs in memory (0 means unbounded). queueSize
+// sizes the buffer of tasks waiting for a free worker, so Enqueue can
+// return to the request goroutine without waiting for one; once that
+// buffer itself fills up, Enqueue blocks until a worker frees a slot.
+// When dir is non-empty, every finished job is also written there as
+// <id>.json.
+func newJobQueue(workers, queueSize, capacity int, dir string) *jobQueue {
+	q := &jobQueue{
+		jobs:     make(map[string]*Job),
+		capacity: capacity,
+		dir:      dir,
+		tasks:    make(chan task, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *jobQueue) work() {
+	for t := range q.tasks {
+		q.mu.Lock()
+		t.job.Status = JobRunning
+		q.mu.Unlock()
+
+		out, err := t.run()
+
+		q.mu.Lock()
+		t.job.Log = out
+		if err != nil {
+			t.job.Status = JobFailed
+			t.job.ExitCode = 1
+		} else {
+			t.job.Status = JobDone
+			t.job.ExitCode = 0
+		}
+		q.mu.Unlock()
+		t.logger.Debug("job finished", "job_id", t.job.ID, "status", t.job.Status, "err", err)
+		q.persist(t.job)
+	}
+}
+
+// Enqueue records a new queued job for event and schedules run to
+// execute it on the next free worker.
+func (q *jobQueue) Enqueue(logger *slog.Logger, event, delivery string, run func() (string, error)) *Job {
+	q.mu.Lock()
+	q.nextID++
+	job := &Job{
+		ID:        strconv.FormatUint(q.nextID, 10),
+		Event:     event,
+		Delivery:  delivery,
+		Status:    JobQueued,
+		CreatedAt: time.Now(),
+	}
+	q.jobs[job.ID] = job
+	q.order = append(q.order, job.ID)
+	if q.capacity > 0 {
+		for len(q.order) > q.capacity {
+			delete(q.jobs, q.order[0])
+			q.order = q.order[1:]
+		}
+	}
+	q.mu.Unlock()
+	q.tasks <- task{job: job, run: run, logger: logger}
+	return job
+}
+
+// Get returns a copy of the job with the given ID.
+func (q *jobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+func (q *jobQueue) persist(job *Job) {
+	if q.dir == "" {
+		return
+	}
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		slog.Error("creating -jobs-dir", "err", err)
+		return
+	}
+	b, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		slog.Error("marshalling job", "err", err)
+		return
+	}
+	name := filepath.Join(q.dir, job.ID+".json")
+	if err := ioutil.WriteFile(name, b, 0644); err != nil {
+		slog.Error("writing job file", "name", name, "err", err)
+	}
+}
+
+// jobEvents adapts a runner to the All(event, payload) method expected
+// by webhook.New, enqueueing a job instead of executing synchronously.
+// It is constructed fresh for every request so job and delivery never
+// leak between requests.
+type jobEvents struct {
+	queue    *jobQueue
+	inner    runner
+	delivery string
+	logger   *slog.Logger
+	job      *Job
+}
+
+func (e *jobEvents) All(event string, payload interface{}) {
+	e.job = e.queue.Enqueue(e.logger, event, e.delivery, func() (string, error) {
+		return e.inner.Run(e.logger, event, payload)
+	})
+}
+
+// jobsHandler verifies and accepts events synchronously, then returns
+// HTTP 202 with the queued job's ID, without waiting for it to run.
+type jobsHandler struct {
+	secret  string
+	queue   *jobQueue
+	inner   runner
+	forward *forwarder
+}
+
+func (h jobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFromContext(r.Context())
+	var body []byte
+	if h.forward != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "error reading body", http.StatusInternalServerError)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	events := &jobEvents{
+		queue:    h.queue,
+		inner:    h.inner,
+		delivery: r.Header.Get("X-GitHub-Delivery"),
+		logger:   logger,
+	}
+	rec := httptest.NewRecorder()
+	webhook.New(h.secret, events).ServeHTTP(rec, r)
+	if events.job == nil {
+		// Verification failed or the event was otherwise rejected before
+		// dispatch; forward webhook.New's response unchanged.
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+		return
+	}
+	if h.forward != nil {
+		h.forward.Forward(logger, events.job.Event, events.job.Delivery, body)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+events.job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		ID string `json:"id"`
+	}{events.job.ID})
+}
+
+// jobStatusHandler serves GET /jobs/<id>.
+type jobStatusHandler struct {
+	queue *jobQueue
+}
+
+func (h jobStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	job, ok := h.queue.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
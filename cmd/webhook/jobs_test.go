@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// TestJobQueueEnqueueNonBlocking verifies that Enqueue returns to the
+// caller as soon as a task fits in the queue's buffer, even while every
+// worker is still busy with an earlier job.
+func TestJobQueueEnqueueNonBlocking(t *testing.T) {
+	q := newJobQueue(1, 2, 0, "")
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	// Occupy the single worker so nothing drains the queue.
+	q.Enqueue(discardLogger(), "push", "d0", func() (string, error) {
+		close(started)
+		<-release
+		return "", nil
+	})
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		// These fill the queue-size-2 buffer; neither should block on
+		// the busy worker.
+		q.Enqueue(discardLogger(), "push", "d1", func() (string, error) { return "", nil })
+		q.Enqueue(discardLogger(), "push", "d2", func() (string, error) { return "", nil })
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue blocked the caller despite free queue capacity")
+	}
+	close(release)
+}
+
+func TestJobQueueGetUnknown(t *testing.T) {
+	q := newJobQueue(1, 1, 0, "")
+	if _, ok := q.Get("missing"); ok {
+		t.Fatal("Get returned ok for a job that was never enqueued")
+	}
+}
+
+func TestJobQueueCapacityEvicts(t *testing.T) {
+	q := newJobQueue(1, 4, 1, "")
+	j1 := q.Enqueue(discardLogger(), "push", "d1", func() (string, error) { return "", nil })
+	// Enqueue evicts the oldest job synchronously once len(order) exceeds
+	// capacity, before the task is even handed to a worker.
+	j2 := q.Enqueue(discardLogger(), "push", "d2", func() (string, error) { return "", nil })
+	if _, ok := q.Get(j1.ID); ok {
+		t.Fatalf("job %s should have been evicted once capacity (1) was exceeded", j1.ID)
+	}
+	if _, ok := q.Get(j2.ID); !ok {
+		t.Fatalf("job %s should still be in the buffer", j2.ID)
+	}
+}
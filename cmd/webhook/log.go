@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// newLogger builds the base slog.Logger from the -log-format and
+// -log-level flags. format is "text" or "json"; level is one of
+// "debug", "info", "warn" or "error".
+func newLogger(w *os.File, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	switch level {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "info", "":
+		lvl = slog.LevelInfo
+	case "warn":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return nil, fmt.Errorf("invalid -log-level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text", "":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid -log-format %q", format)
+	}
+	return slog.New(h), nil
+}
+
+type loggerKeyType struct{}
+
+var loggerKey loggerKeyType
+
+// withLogger returns a context carrying logger, retrievable with
+// loggerFromContext.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// loggerFromContext returns the logger stashed by the logging middleware,
+// or slog.Default() if none was attached (e.g. in tests).
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// loggingMiddleware attaches a request-scoped logger, with fields
+// delivery_id, event, repo and remote_addr, to the request context
+// before calling next. The same logger is used by the templater,
+// notifier dispatch and dumper so that a script error and a -debug dump
+// for the same delivery share identical fields.
+type loggingMiddleware struct {
+	next    http.Handler
+	logHTTP bool
+	base    *slog.Logger
+}
+
+// peekRepo reads the full request body so the repository name can be
+// extracted for logging, then returns a fresh body so later handlers can
+// still read it from the start.
+func peekRepo(r *http.Request) (io.ReadCloser, string) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return r.Body, ""
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), repoFullName(b)
+}
+
+func (m loggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, repo := peekRepo(r)
+	r.Body = body
+
+	logger := m.base.With(
+		"delivery_id", r.Header.Get("X-GitHub-Delivery"),
+		"event", r.Header.Get("X-GitHub-Event"),
+		"repo", repo,
+		"remote_addr", r.RemoteAddr,
+	)
+	if m.logHTTP {
+		logger.Info("http request", "method", r.Method, "url", r.URL.String(), "proto", r.Proto)
+	}
+	r = r.WithContext(withLogger(r.Context(), logger))
+	m.next.ServeHTTP(w, r)
+}
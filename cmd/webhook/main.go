@@ -24,9 +24,11 @@
 //   env
 //   	An alias for os.Getenv.
 //   log
-//   	An alias for log.Println. Used only for side-effect, returns empty string.
+//   	Logs its arguments at info level on the request's logger. Used only
+//   	for side-effect, returns empty string.
 //   logf
-//   	An alias for log.Printf. Used only for side-effect, returns empty string.
+//   	Like log, but formats its arguments with fmt.Sprintf first. Used only
+//   	for side-effect, returns empty string.
 //   exec
 //   	An alias for exec.Command. Returned value is the process' output read
 //   	from its os.Stdout.
@@ -62,6 +64,39 @@
 // The -debug flag makes webhook dump each received JSON payload into
 // $PWD/testdata/<event>-<timestamp>.json file.
 //
+// The -notify flag points at a JSON configuration file mapping event names
+// to one or more notification sinks (SMTP e-mail, a generic JSON HTTP
+// webhook, or third-party providers registered with the notifier
+// package). Each sink renders its own subject/body templates and is
+// dispatched after the template script runs.
+//
+// The -scripts flag selects an alternate mode, mutually exclusive with
+// both the script argument and -notify, where events are routed to
+// executables under a directory tree instead of a single template
+// script; see -scripts, -hook-timeout, -hook-default-ext and
+// -hook-log-dir below for details.
+//
+// Outside of -scripts mode, accepted events are queued and run on a
+// bounded worker pool; see -workers, -jobs-buffer, -jobs-dir and the
+// GET /jobs/<id> endpoint below for details.
+//
+// Logging uses log/slog; see -log-format and -log-level below to control
+// the output format and verbosity, and -log-http-request to additionally
+// log each request line. Every request gets a child logger carrying
+// delivery_id, event, repo and remote_addr fields.
+//
+// The -allow-cidr, -auth and -client-ca flags add gates in front of the
+// webhook handler, on top of GitHub's HMAC signature: restricting the
+// remote addresses allowed to connect, requiring HTTP Basic auth, and
+// requiring mTLS client certificates, respectively. See their usage text
+// below for details. The TLS listener enabled by -cert/-key also
+// defaults to a modern configuration, enabling TLS 1.3.
+//
+// The -forward flag (repeatable) re-emits every verified payload as an
+// HTTP POST to one or more downstream URLs, re-signing each with its own
+// secret from -forward-secrets; see -forward, -forward-secrets and
+// -forward-ca below for details.
+//
 // The script argument is a path to the template script file which is used as a handler
 // for incoming events.
 package main
@@ -70,11 +105,12 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -83,7 +119,7 @@ import (
 	"text/template"
 	"time"
 
-	"github.com/rjeczalik/gh/webhook"
+	"github.com/rjeczalik/gh/notifier"
 )
 
 const usage = `usage: webhook [-cert file -key file] [-addr address] [-log file] -secret key script
@@ -109,9 +145,11 @@ script has registered extra control functions:
 	env
 		An alias for os.Getenv.
 	log
-		An alias for log.Println. Used only for side-effect, returns empty string.
+		Logs its arguments at info level on the request's logger. Used only
+		for side-effect, returns empty string.
 	logf
-		An alias for log.Printf. Used only for side-effect, returns empty string.
+		Like log, but formats its arguments with fmt.Sprintf first. Used only
+		for side-effect, returns empty string.
 	exec
 		An alias for exec.Command. Returned value is the process' output read
 		from its os.Stdout.
@@ -147,6 +185,76 @@ The -log flag redirects output to the given file.
 The -debug flag makes webhook dump each received JSON payload into
 $PWD/testdata/<event>-<timestamp>.json file.
 
+The -notify flag points at a JSON configuration file mapping event names to
+one or more notification sinks, for example:
+
+	{
+		"push": [
+			{"type": "smtp", "subject": "push to {{.Payload.Repository.Name}}", "body": "{{.Payload.Pusher.Email}}", "params": {"addr": "smtp.example.com:587", "from": "hook@example.com", "to": "ops@example.com"}}
+		]
+	}
+
+Built-in sink types are "smtp", "http" and "noop"; third parties can
+register additional types with notifier.Register.
+
+The -scripts flag selects an alternate mode, mutually exclusive with the
+script argument, where events are routed to executables under a
+directory tree instead of a single template script, e.g.
+scripts/push/main.sh or scripts/pull_request/opened.sh chosen by the
+X-GitHub-Event header and the payload's "action" field. The child
+process receives the raw JSON payload on stdin and the GH_EVENT,
+GH_DELIVERY and GH_REPO environment variables, and its combined
+stdout/stderr is streamed back to the HTTP client as it is produced,
+using Server-Sent Events when the request's Accept header asks for
+text/event-stream. The -hook-timeout flag bounds how long a script may
+run before it is killed, -hook-default-ext sets the extension appended
+when looking up scripts (e.g. ".sh"), and -hook-log-dir, when set,
+keeps a copy of every hook's combined output.
+
+Outside of -scripts mode, signature verification happens synchronously
+but the template script (and any -notify sinks) run on a bounded pool of
+-workers goroutines (default 2), so a slow hook can no longer make
+GitHub's delivery time out. Enqueue never blocks the request on a worker
+becoming free: accepted events wait in a buffer of -queue-size jobs
+(default 64) instead, and Enqueue only blocks once that buffer itself is
+full. Each accepted event is answered immediately with HTTP 202 and a
+JSON body of the form {"id": "<job-id>"} plus a Location: /jobs/<job-id>
+header; GET /jobs/<job-id> then reports the job's status (queued,
+running, done or failed), exit code and captured log. The last
+-jobs-buffer jobs (default 100, 0 for unbounded) are kept in memory, and
+-jobs-dir additionally persists each finished job as <id>.json.
+
+-scripts is mutually exclusive with -notify as well as with the script
+argument: scripts mode dispatches to child processes instead of the
+templater/notifier chain, so a -notify config passed alongside -scripts
+would silently never be wired up.
+
+Logging uses log/slog. The -log-format flag selects "text" (default) or
+"json" output, and -log-level selects "debug", "info" (default), "warn"
+or "error". Every request gets a child logger carrying delivery_id,
+event, repo and remote_addr fields, which is used for template script
+errors, -notify failures and -debug dumps, so they can all be
+correlated by delivery_id. The -log-http-request flag additionally logs
+the request line (method, URL, protocol) at info level.
+
+The -allow-cidr flag (repeatable) restricts which remote addresses may
+reach the webhook, e.g. to GitHub's published hook CIDR blocks. The
+-auth flag adds an additional gate in front of signature verification;
+currently only "basic" is supported, configured with -auth-param
+"user:passhash" where passhash is hex(sha256(password)). The -client-ca
+flag, used together with -cert/-key, enables mTLS by requiring and
+verifying a client certificate signed by the given CA against every
+connection.
+
+The -forward flag (repeatable) re-emits every verified payload as an
+HTTP POST to one or more downstream URLs, preserving the X-GitHub-Event
+and X-GitHub-Delivery headers and rewriting the Host header to match
+each destination. -forward-secrets points at a JSON config mapping a
+destination URL to the secret used to compute a fresh
+X-Hub-Signature-256 for it; destinations missing from the config are
+forwarded unsigned. -forward-ca supplies extra root CAs for HTTPS
+destinations with certificates not in the system trust store.
+
 The script argument is a path to the template script file which is used as a handler
 for incoming events.`
 
@@ -157,41 +265,73 @@ var (
 	secret  = flag.String("secret", "", "GitHub secret value used for signing payloads.")
 	debug   = flag.Bool("debug", false, "Dumps verified payloads into testdata directory.")
 	logfile = flag.String("log", "", "Redirects output to the given file.")
+	notify  = flag.String("notify", "", "Path to a JSON config mapping events to notification sinks.")
+
+	scripts        = flag.String("scripts", "", "Directory of per-event scripts. Mutually exclusive with the script argument.")
+	hookTimeout    = flag.Duration("hook-timeout", 0, "Kills a -scripts hook that runs longer than this. 0 means no limit.")
+	hookDefaultExt = flag.String("hook-default-ext", "", "Extension appended when looking up -scripts hooks, e.g. \".sh\".")
+	hookLogDir     = flag.String("hook-log-dir", "", "Directory to store a copy of each -scripts hook's combined output.")
+
+	workers    = flag.Int("workers", 2, "Number of workers executing queued jobs concurrently.")
+	queueSize  = flag.Int("queue-size", 64, "Number of jobs that may wait for a free worker before Enqueue blocks.")
+	jobsBuffer = flag.Int("jobs-buffer", 100, "Number of past jobs to keep in memory. 0 means unbounded.")
+	jobsDir    = flag.String("jobs-dir", "", "Directory to additionally persist each finished job as JSON.")
+
+	logFormat      = flag.String("log-format", "text", `Log output format, "text" or "json".`)
+	logLevel       = flag.String("log-level", "info", `Minimum log level: "debug", "info", "warn" or "error".`)
+	logHTTPRequest = flag.Bool("log-http-request", false, "Logs the full request line for every delivery at info level.")
+
+	auth      = flag.String("auth", "", `Extra auth gate in front of the webhook, currently only "basic".`)
+	authParam = flag.String("auth-param", "", "Parameter for -auth. For basic: \"user:passhash\" where passhash is hex(sha256(password)).")
+	clientCA  = flag.String("client-ca", "", "CA certificate file used to require and verify client certificates (mTLS). Requires -cert/-key.")
+
+	forwardSecrets = flag.String("forward-secrets", "", "JSON config mapping a -forward URL to the secret used to re-sign requests to it.")
+	forwardCA      = flag.String("forward-ca", "", "PEM file of extra root CAs trusted for HTTPS -forward destinations.")
 )
 
+var (
+	allowCIDRFlag cidrList
+	forwardFlag   urlList
+)
+
+func init() {
+	flag.Var(&allowCIDRFlag, "allow-cidr", "CIDR block allowed to reach the webhook. Repeatable; omit to allow any address.")
+	flag.Var(&forwardFlag, "forward", "Downstream URL to re-emit verified payloads to. Repeatable.")
+}
+
 type Event struct {
 	Name    string      // https://developer.github.com/webhooks/#events
 	Payload interface{} // https://developer.github.com/v3/activity/events/types/
 }
 
-var scriptFuncs = template.FuncMap{
-	"env": func(s string) string {
-		return os.Getenv(s)
-	},
-	"exec": func(cmd string, args ...string) (string, error) {
-		out, err := exec.Command(cmd, args...).Output()
-		if *debug {
-			log.Printf("[DEBUG] exec cmd=%s args=%q err=%v", cmd, args, err)
-		}
-		return string(bytes.TrimSpace(out)), err
-	},
-	"log": func(v ...interface{}) string {
-		if len(v) != 0 {
-			log.Println(v...)
-		}
-		return ""
-	},
-	"logf": func(format string, v ...interface{}) string {
-		if format == "" {
+// scriptFuncs builds the FuncMap bound to a single request's logger, so
+// that log/logf calls made from within the template script carry the
+// same delivery_id, event, repo and remote_addr fields as everything
+// else handling that request.
+func scriptFuncs(logger *slog.Logger) template.FuncMap {
+	return template.FuncMap{
+		"env": func(s string) string {
+			return os.Getenv(s)
+		},
+		"exec": func(cmd string, args ...string) (string, error) {
+			out, err := exec.Command(cmd, args...).Output()
+			logger.Debug("exec", "cmd", cmd, "args", args, "err", err)
+			return string(bytes.TrimSpace(out)), err
+		},
+		"log": func(v ...interface{}) string {
+			if len(v) != 0 {
+				logger.Info(fmt.Sprint(v...))
+			}
 			return ""
-		}
-		if len(v) == 0 {
-			log.Printf("%s", format)
-		} else {
-			log.Printf(format, v...)
-		}
-		return ""
-	},
+		},
+		"logf": func(format string, v ...interface{}) string {
+			if format == "" {
+				return ""
+			}
+			logger.Info(fmt.Sprintf(format, v...))
+			return ""
+		},
+	}
 }
 
 type templater struct {
@@ -199,19 +339,111 @@ type templater struct {
 }
 
 func newTemplater(file string) (templater, error) {
-	tmpl := template.New(filepath.Base(file)).Funcs(scriptFuncs)
-	tmpl, err := tmpl.ParseFiles(flag.Arg(0))
+	// The script funcs must be defined at parse time; Run rebinds them to
+	// each request's logger.
+	tmpl, err := template.New(filepath.Base(file)).Funcs(scriptFuncs(slog.Default())).ParseFiles(flag.Arg(0))
 	if err != nil {
 		return templater{}, err
 	}
 	return templater{tmpl: tmpl}, nil
 }
 
-func (h templater) All(event string, payload interface{}) {
-	if err := h.tmpl.Execute(ioutil.Discard, Event{Name: event, Payload: payload}); err != nil {
-		log.Println("ERROR template error:", err)
-		return
+// Run executes the template script for event and returns its rendered
+// output, which the job queue keeps as the job's log. tmpl is cloned
+// per call so that per-request script funcs never race across workers
+// sharing the same parsed template.
+func (h templater) Run(logger *slog.Logger, event string, payload interface{}) (string, error) {
+	tmpl, err := h.tmpl.Clone()
+	if err != nil {
+		return "", err
 	}
+	tmpl = tmpl.Funcs(scriptFuncs(logger))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, Event{Name: event, Payload: payload}); err != nil {
+		logger.Error("template error", "err", err)
+		return buf.String(), err
+	}
+	return buf.String(), nil
+}
+
+// sink pairs a notifier.Notifier with its subject/body templates.
+type sink struct {
+	notifier.Notifier
+	subject *template.Template
+	body    *template.Template
+}
+
+// notifyDispatcher sends rendered notifications to the sinks configured
+// for each event name. The zero value has no sinks and Dispatch is a no-op.
+type notifyDispatcher struct {
+	sinks map[string][]sink
+}
+
+// loadNotifyConfig reads a -notify configuration file and constructs a
+// notifyDispatcher from it.
+func loadNotifyConfig(file string) (notifyDispatcher, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return notifyDispatcher{}, err
+	}
+	var cfg map[string][]notifier.Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return notifyDispatcher{}, fmt.Errorf("invalid -notify config: %v", err)
+	}
+	d := notifyDispatcher{sinks: make(map[string][]sink, len(cfg))}
+	for event, configs := range cfg {
+		for _, c := range configs {
+			n, err := notifier.New(c)
+			if err != nil {
+				return notifyDispatcher{}, fmt.Errorf("event %q: %v", event, err)
+			}
+			subject, err := template.New("subject").Parse(c.Subject)
+			if err != nil {
+				return notifyDispatcher{}, fmt.Errorf("event %q: subject: %v", event, err)
+			}
+			body, err := template.New("body").Parse(c.Body)
+			if err != nil {
+				return notifyDispatcher{}, fmt.Errorf("event %q: body: %v", event, err)
+			}
+			d.sinks[event] = append(d.sinks[event], sink{Notifier: n, subject: subject, body: body})
+		}
+	}
+	return d, nil
+}
+
+// Dispatch renders and delivers the notifications configured for event.
+// Errors are logged, not returned, so a failing sink never affects the
+// HTTP response or the template script.
+func (d notifyDispatcher) Dispatch(logger *slog.Logger, event string, payload interface{}) {
+	for _, s := range d.sinks[event] {
+		var subject, body bytes.Buffer
+		ev := Event{Name: event, Payload: payload}
+		if err := s.subject.Execute(&subject, ev); err != nil {
+			logger.Error("notify subject template error", "err", err)
+			continue
+		}
+		if err := s.body.Execute(&body, ev); err != nil {
+			logger.Error("notify body template error", "err", err)
+			continue
+		}
+		msg := notifier.Message{Event: event, Subject: subject.String(), Body: body.String()}
+		if err := s.Notify(msg); err != nil {
+			logger.Error("notify", "err", err)
+		}
+	}
+}
+
+// notifyingTemplater runs the template script and then dispatches
+// notifications for the same event.
+type notifyingTemplater struct {
+	templater
+	notify notifyDispatcher
+}
+
+func (h notifyingTemplater) Run(logger *slog.Logger, event string, payload interface{}) (string, error) {
+	out, err := h.templater.Run(logger, event, payload)
+	h.notify.Dispatch(logger, event, payload)
+	return out, err
 }
 
 type dumper struct {
@@ -221,30 +453,31 @@ type dumper struct {
 func (d dumper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var buf bytes.Buffer
 	req.Body = ioutil.NopCloser(io.TeeReader(req.Body, &buf))
+	logger := loggerFromContext(req.Context())
 	d.Handler.ServeHTTP(w, req)
-	go dump(req.Header.Get("X-GitHub-Event"), buf.Bytes())
+	go dump(logger, req.Header.Get("X-GitHub-Event"), buf.Bytes())
 }
 
 func now() string {
 	return time.Now().UTC().Format("2006-01-02 at 03.04.05.000")
 }
 
-func dump(event string, p []byte) {
+func dump(logger *slog.Logger, event string, p []byte) {
 	switch {
 	case event == "":
-		log.Println("[DEBUG] ERROR empty event name")
+		logger.Debug("empty event name")
 		return
 	case len(p) == 0:
-		log.Println("[DEBUG] ERROR empty payload")
+		logger.Debug("empty payload")
 		return
 	}
 	if err := os.MkdirAll("testdata", 0755); err != nil {
-		log.Println("[DEBUG] ERROR creating testdata:", err)
+		logger.Debug("creating testdata", "err", err)
 		return
 	}
 	name := filepath.Join("testdata", fmt.Sprintf("%s-%s.json", event, now()))
 	if err := ioutil.WriteFile(name, p, 0644); err != nil {
-		log.Printf("[DEBUG] ERROR creating %s: %v", name, err)
+		logger.Debug("creating dump file", "name", name, "err", err)
 	}
 }
 
@@ -270,24 +503,62 @@ func main() {
 		fmt.Fprintln(os.Stderr, usage)
 	}
 	flag.Parse()
-	if flag.NArg() != 1 || flag.Arg(0) == "" {
+	if *scripts != "" {
+		if flag.NArg() != 0 {
+			die("-scripts is mutually exclusive with the script argument")
+		}
+		if *notify != "" {
+			die("-scripts is mutually exclusive with -notify")
+		}
+	} else if flag.NArg() != 1 || flag.Arg(0) == "" {
 		die("invalid number of arguments")
 	}
 	if (*cert == "") != (*key == "") {
 		die("both -cert and -key flags must be provided")
 	}
+	if *clientCA != "" && *cert == "" {
+		die("-client-ca requires -cert and -key")
+	}
+	var authUser, authPassHash string
+	if *auth != "" {
+		if *auth != "basic" {
+			die(fmt.Sprintf("unsupported -auth %q", *auth))
+		}
+		var err error
+		authUser, authPassHash, err = parseAuthParam(*authParam)
+		if err != nil {
+			die(err)
+		}
+	}
+	logOut := os.Stdout
 	if *logfile != "" {
 		f, err := os.OpenFile(*logfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			die(err)
 		}
-		log.SetOutput(f)
+		logOut = f
 		defer f.Close()
 	}
-	tmpl, err := newTemplater(flag.Arg(0))
+	logger, err := newLogger(logOut, *logFormat, *logLevel)
 	if err != nil {
 		die(err)
 	}
+	slog.SetDefault(logger)
+	var events runner
+	if *scripts == "" {
+		tmpl, err := newTemplater(flag.Arg(0))
+		if err != nil {
+			die(err)
+		}
+		events = tmpl
+		if *notify != "" {
+			d, err := loadNotifyConfig(*notify)
+			if err != nil {
+				die(err)
+			}
+			events = notifyingTemplater{templater: tmpl, notify: d}
+		}
+	}
 	var listener net.Listener
 	if *cert != "" {
 		crt, err := tls.LoadX509KeyPair(*cert, *key)
@@ -297,21 +568,17 @@ func main() {
 		cfg := &tls.Config{
 			Certificates: []tls.Certificate{crt},
 			Rand:         rand.Reader,
-			// Don't offer SSL3.
-			MinVersion: tls.VersionTLS10,
-			MaxVersion: tls.VersionTLS12,
-			// Don't offer RC4 ciphers.
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
-				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			},
+			// Don't offer SSL3 or TLS1.0/1.1; TLS1.3 is negotiated
+			// automatically when both ends support it.
+			MinVersion: tls.VersionTLS12,
+		}
+		if *clientCA != "" {
+			pool, err := loadCertPool(*clientCA)
+			if err != nil {
+				die(err)
+			}
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
 		}
 		l, err := tls.Listen("tcp", nonil(*addr, "0.0.0.0:8443"), cfg)
 		if err != nil {
@@ -325,11 +592,41 @@ func main() {
 		}
 		listener = l
 	}
-	var handler http.Handler = webhook.New(*secret, tmpl)
+	var fwd *forwarder
+	if len(forwardFlag) > 0 {
+		var err error
+		fwd, err = newForwarder(forwardFlag, *forwardSecrets, *forwardCA)
+		if err != nil {
+			die(err)
+		}
+	}
+	mux := http.NewServeMux()
+	var ingest http.Handler
+	if *scripts != "" {
+		ingest = scriptsHandler{
+			dir:        *scripts,
+			secret:     *secret,
+			defaultExt: *hookDefaultExt,
+			timeout:    *hookTimeout,
+			logDir:     *hookLogDir,
+			forward:    fwd,
+		}
+	} else {
+		queue := newJobQueue(*workers, *queueSize, *jobsBuffer, *jobsDir)
+		mux.Handle("/jobs/", jobStatusHandler{queue: queue})
+		ingest = jobsHandler{secret: *secret, queue: queue, inner: events, forward: fwd}
+	}
 	if *debug {
-		handler = dumper{Handler: handler}
+		ingest = dumper{Handler: ingest}
+	}
+	mux.Handle("/", ingest)
+	var handler http.Handler = mux
+	if *auth == "basic" {
+		handler = basicAuthMiddleware{next: handler, user: authUser, passHash: authPassHash}
 	}
-	log.Printf("INFO Listening on %s . . .", listener.Addr())
+	handler = allowCIDRMiddleware{next: handler, blocks: allowCIDRFlag}
+	handler = loggingMiddleware{next: handler, logHTTP: *logHTTPRequest, base: logger}
+	logger.Info("listening", "addr", listener.Addr())
 	if err := http.Serve(listener, handler); err != nil {
 		die(err)
 	}
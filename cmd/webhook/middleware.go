@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// loadCertPool reads a PEM-encoded CA certificate file for use as
+// tls.Config.ClientCAs.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// cidrList is a repeatable -allow-cidr flag value.
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	s := make([]string, len(*l))
+	for i, n := range *l {
+		s[i] = n.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *cidrList) Set(s string) error {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return fmt.Errorf("invalid -allow-cidr %q: %v", s, err)
+	}
+	*l = append(*l, n)
+	return nil
+}
+
+// allowCIDRMiddleware rejects requests whose remote address does not
+// fall within any of the configured CIDR blocks. An empty list allows
+// everything, matching GitHub's published hook IP ranges when populated
+// with them.
+type allowCIDRMiddleware struct {
+	next   http.Handler
+	blocks []*net.IPNet
+}
+
+func (m allowCIDRMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(m.blocks) == 0 {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, b := range m.blocks {
+		if ip != nil && b.Contains(ip) {
+			m.next.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.Error(w, "forbidden", http.StatusForbidden)
+}
+
+// basicAuthMiddleware gates access behind HTTP Basic auth, comparing the
+// supplied password's SHA-256 digest against a precomputed hex hash so
+// the plaintext password is never stored in configuration.
+type basicAuthMiddleware struct {
+	next     http.Handler
+	user     string
+	passHash string // hex-encoded sha256 of the expected password
+}
+
+func (m basicAuthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	sum := sha256.Sum256([]byte(pass))
+	passHash := hex.EncodeToString(sum[:])
+	// Compare both fields unconditionally (bitwise AND, not ||) so a
+	// wrong username doesn't short-circuit the password comparison and
+	// leak which part of the credential failed via timing.
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(m.user))
+	passOK := subtle.ConstantTimeCompare([]byte(passHash), []byte(m.passHash))
+	if !ok || userOK&passOK != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="webhook"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	m.next.ServeHTTP(w, r)
+}
+
+// parseAuthParam splits a -auth-param value of the form "user:passhash".
+func parseAuthParam(s string) (user, passHash string, err error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid -auth-param %q, want user:passhash", s)
+	}
+	return s[:i], s[i+1:], nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func passHashOf(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	m := basicAuthMiddleware{
+		next:     http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }),
+		user:     "ops",
+		passHash: passHashOf("s3cret"),
+	}
+
+	cases := []struct {
+		name       string
+		user, pass string
+		setAuth    bool
+		wantStatus int
+	}{
+		{"correct credentials", "ops", "s3cret", true, http.StatusOK},
+		{"wrong password", "ops", "wrong", true, http.StatusUnauthorized},
+		{"wrong user", "nobody", "s3cret", true, http.StatusUnauthorized},
+		{"no credentials", "", "", false, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if c.setAuth {
+				r.SetBasicAuth(c.user, c.pass)
+			}
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != c.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, c.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAllowCIDRMiddleware(t *testing.T) {
+	_, block, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := allowCIDRMiddleware{
+		next:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }),
+		blocks: []*net.IPNet{block},
+	}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		wantStatus int
+	}{
+		{"inside allowed block", "192.0.2.10:54321", http.StatusOK},
+		{"outside allowed block", "203.0.113.5:54321", http.StatusForbidden},
+		{"ipv6 remote addr", "[2001:db8::1]:54321", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.RemoteAddr = c.remoteAddr
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, r)
+			if w.Code != c.wantStatus {
+				t.Fatalf("got status %d, want %d", w.Code, c.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scriptsHandler routes incoming events to executables under a directory
+// tree, e.g. scripts/push/main.sh or scripts/pull_request/opened.sh,
+// chosen by the X-GitHub-Event header and the payload's "action" field.
+// It verifies the payload signature itself, since webhook.New has no way
+// to stream a handler's output back to the HTTP client.
+type scriptsHandler struct {
+	dir        string
+	secret     string
+	defaultExt string
+	timeout    time.Duration
+	logDir     string
+	forward    *forwarder
+}
+
+func (h scriptsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFromContext(r.Context())
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusInternalServerError)
+		return
+	}
+	if !verifySignature(h.secret, body, r.Header.Get("X-Hub-Signature-256"), r.Header.Get("X-Hub-Signature")) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	event := r.Header.Get("X-GitHub-Event")
+	delivery := r.Header.Get("X-GitHub-Delivery")
+	if event == "" {
+		http.Error(w, "missing X-GitHub-Event header", http.StatusBadRequest)
+		return
+	}
+	if h.forward != nil {
+		h.forward.Forward(logger, event, delivery, body)
+	}
+	script, err := h.resolve(event, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	ctx := r.Context()
+	var cancel context.CancelFunc
+	if h.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Stdin = strings.NewReader(string(body))
+	cmd.Env = append(os.Environ(),
+		"GH_EVENT="+event,
+		"GH_DELIVERY="+delivery,
+		"GH_REPO="+repoFullName(body),
+	)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cmd.Stderr = cmd.Stdout
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	flusher, _ := w.(http.Flusher)
+	w.WriteHeader(http.StatusOK)
+	logw := h.openLog(logger, event, delivery)
+	if logw != nil {
+		defer logw.Close()
+	}
+	if err := cmd.Start(); err != nil {
+		logger.Error("starting hook", "script", script, "err", err)
+		fmt.Fprintf(w, "error starting %s: %v\n", script, err)
+		return
+	}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if logw != nil {
+			fmt.Fprintln(logw, line)
+		}
+		if sse {
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		} else {
+			fmt.Fprintln(w, line)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			logger.Error("hook timed out", "script", script, "timeout", h.timeout)
+			fmt.Fprintf(w, "error: %s timed out after %s\n", script, h.timeout)
+		} else {
+			logger.Error("hook failed", "script", script, "err", err)
+			fmt.Fprintf(w, "error: %s: %v\n", script, err)
+		}
+	}
+}
+
+// resolve picks the script executable for event, preferring an
+// action-specific script (e.g. opened) and falling back to main; each
+// name is tried as is and then with h.defaultExt appended.
+// event and the payload's action come straight from the request, so
+// both are confined to a single path segment under h.dir before being
+// joined into a filesystem path.
+func (h scriptsHandler) resolve(event string, body []byte) (string, error) {
+	dir, err := safeJoin(h.dir, event)
+	if err != nil {
+		return "", fmt.Errorf("invalid event %q: %v", event, err)
+	}
+	var candidates []string
+	if action := payloadAction(body); action != "" {
+		c, err := safeJoin(dir, action)
+		if err != nil {
+			return "", fmt.Errorf("invalid action %q: %v", action, err)
+		}
+		candidates = append(candidates, c)
+	}
+	candidates = append(candidates, filepath.Join(dir, "main"))
+	for _, c := range candidates {
+		for _, name := range []string{c, c + h.defaultExt} {
+			if fi, err := os.Stat(name); err == nil && !fi.IsDir() {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no script found for event %q under %s", event, dir)
+}
+
+// safeJoin joins elem onto dir as a single path segment, rejecting any
+// element that contains a path separator or traverses outside dir (e.g.
+// "..", "../../etc/passwd" or an absolute path).
+func safeJoin(dir, elem string) (string, error) {
+	if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, `/\`) {
+		return "", fmt.Errorf("must be a single path segment")
+	}
+	joined := filepath.Join(dir, elem)
+	if joined != dir && !strings.HasPrefix(joined, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("escapes %s", dir)
+	}
+	return joined, nil
+}
+
+func (h scriptsHandler) openLog(logger *slog.Logger, event, delivery string) io.WriteCloser {
+	if h.logDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(h.logDir, 0755); err != nil {
+		logger.Error("creating hook log dir", "err", err)
+		return nil
+	}
+	name := filepath.Join(h.logDir, fmt.Sprintf("%s-%s.log", event, delivery))
+	f, err := os.Create(name)
+	if err != nil {
+		logger.Error("creating hook log", "err", err)
+		return nil
+	}
+	return f
+}
+
+func verifySignature(secret string, body []byte, sig256, sig1 string) bool {
+	if secret == "" {
+		return true
+	}
+	if sig256 != "" {
+		return hmacEqual(sha256.New, secret, body, strings.TrimPrefix(sig256, "sha256="))
+	}
+	if sig1 != "" {
+		return hmacEqual(sha1.New, secret, body, strings.TrimPrefix(sig1, "sha1="))
+	}
+	return false
+}
+
+func hmacEqual(newHash func() hash.Hash, secret string, body []byte, sig string) bool {
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+func payloadAction(body []byte) string {
+	var v struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return ""
+	}
+	return v.Action
+}
+
+func repoFullName(body []byte) string {
+	var v struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return ""
+	}
+	return v.Repository.FullName
+}
+
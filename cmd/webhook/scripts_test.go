@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	dir := string(filepath.Separator) + filepath.Join("scripts", "push")
+
+	cases := []struct {
+		name    string
+		elem    string
+		wantErr bool
+	}{
+		{"simple name", "opened", false},
+		{"dot-dot traversal", "..", true},
+		{"nested traversal", "../../../../etc/passwd", true},
+		{"absolute path", "/etc/passwd", true},
+		{"embedded forward slash", "a/b", true},
+		{"embedded backslash", `a\b`, true},
+		{"empty string", "", true},
+		{"dot", ".", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := safeJoin(dir, c.elem)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("safeJoin(%q, %q) error = %v, wantErr %v", dir, c.elem, err, c.wantErr)
+			}
+		})
+	}
+}
+
+// TestResolveRejectsTraversal proves that a malicious X-GitHub-Event
+// header or payload "action" field cannot make resolve() pick a script
+// outside h.dir.
+func TestResolveRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	pushDir := filepath.Join(root, "push")
+	if err := os.MkdirAll(pushDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(pushDir, "main"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A file outside root that a traversal payload would try to reach.
+	outside := filepath.Join(filepath.Dir(root), "evil")
+	if err := os.WriteFile(outside, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outside)
+
+	h := scriptsHandler{dir: root}
+
+	t.Run("traversal via event", func(t *testing.T) {
+		if _, err := h.resolve("../evil", nil); err == nil {
+			t.Fatal("resolve did not reject a traversal payload in event")
+		}
+	})
+	t.Run("traversal via action", func(t *testing.T) {
+		body := []byte(`{"action":"../../evil"}`)
+		if _, err := h.resolve("push", body); err == nil {
+			t.Fatal("resolve did not reject a traversal payload in action")
+		}
+	})
+	t.Run("legitimate event resolves", func(t *testing.T) {
+		script, err := h.resolve("push", nil)
+		if err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+		want := filepath.Join(pushDir, "main")
+		if script != want {
+			t.Fatalf("resolve returned %q, want %q", script, want)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// httpSink POSTs a generic {event,subject,text} JSON payload to a
+// configured URL. It has no notion of auth or provider-specific request
+// shapes, so it won't work against a real provider API (e.g. Mailgun's
+// form-encoded, Basic-auth-gated /v3/<domain>/messages) out of the box —
+// it's meant for operators' own webhook receivers.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP builds a Notifier that POSTs messages as JSON. Recognized
+// Config.Params keys: url.
+func NewHTTP(cfg Config) (Notifier, error) {
+	url := cfg.Params["url"]
+	if url == "" {
+		return nil, fmt.Errorf("notifier: http requires a url param")
+	}
+	return &httpSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *httpSink) Notify(m Message) error {
+	body, err := json.Marshal(struct {
+		Event   string `json:"event"`
+		Subject string `json:"subject"`
+		Text    string `json:"text"`
+	}{Event: m.Event, Subject: m.Subject, Text: m.Body})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notifier: http: %s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package notifier defines a pluggable interface for dispatching GitHub
+// webhook events to external notification sinks such as e-mail, HTTP
+// endpoints or SMS gateways.
+//
+// Built-in implementations are registered under the names "smtp", "http"
+// and "noop". Third parties may add their own by calling Register with a
+// unique name and a constructor function.
+package notifier
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Message is the rendered content to be delivered by a Notifier.
+type Message struct {
+	Event   string // https://developer.github.com/webhooks/#events
+	Subject string
+	Body    string
+}
+
+// Notifier delivers a Message to a single destination.
+type Notifier interface {
+	Notify(Message) error
+}
+
+// Config is the per-sink configuration as read from the -notify config
+// file. Fields not relevant to a particular sink type are ignored.
+type Config struct {
+	Type    string            `json:"type"`
+	Subject string            `json:"subject"`
+	Body    string            `json:"body"`
+	Params  map[string]string `json:"params"`
+}
+
+// NewFunc constructs a Notifier from sink-specific configuration.
+type NewFunc func(Config) (Notifier, error)
+
+var (
+	mu        sync.Mutex
+	providers = map[string]NewFunc{
+		"smtp": NewSMTP,
+		"http": NewHTTP,
+		"noop": NewNoop,
+	}
+)
+
+// Register adds a named Notifier constructor to the registry, making it
+// available as a "type" value in -notify configuration files. Register
+// panics if name is already registered.
+func Register(name string, fn NewFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := providers[name]; ok {
+		panic("notifier: provider already registered: " + name)
+	}
+	providers[name] = fn
+}
+
+// New looks up the provider named by cfg.Type and constructs a Notifier
+// from it.
+func New(cfg Config) (Notifier, error) {
+	mu.Lock()
+	fn, ok := providers[cfg.Type]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("notifier: unknown provider %q", cfg.Type)
+	}
+	return fn(cfg)
+}
@@ -0,0 +1,44 @@
+package notifier
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+)
+
+// smtpSink delivers messages as e-mail via net/smtp.
+type smtpSink struct {
+	addr string // host:port of the SMTP server
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// NewSMTP builds a Notifier that sends e-mail through an SMTP relay.
+// Recognized Config.Params keys: addr, from, to (comma-separated),
+// user, password.
+func NewSMTP(cfg Config) (Notifier, error) {
+	addr := cfg.Params["addr"]
+	from := cfg.Params["from"]
+	if addr == "" || from == "" {
+		return nil, fmt.Errorf("notifier: smtp requires addr and from params")
+	}
+	to := splitList(cfg.Params["to"])
+	if len(to) == 0 {
+		return nil, fmt.Errorf("notifier: smtp requires a non-empty to param")
+	}
+	var auth smtp.Auth
+	if user := cfg.Params["user"]; user != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		auth = smtp.PlainAuth("", user, cfg.Params["password"], host)
+	}
+	return &smtpSink{addr: addr, auth: auth, from: from, to: to}, nil
+}
+
+func (s *smtpSink) Notify(m Message) error {
+	msg := fmt.Sprintf("Subject: %s\r\n\r\n%s", m.Subject, m.Body)
+	return smtp.SendMail(s.addr, s.auth, s.from, s.to, []byte(msg))
+}